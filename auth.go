@@ -0,0 +1,201 @@
+package webdav
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Principal identifies the authenticated caller of a request.
+type Principal struct {
+	Name string
+}
+
+// ErrUnauthorized is returned by an Authenticator when the request's
+// credentials are missing or invalid.
+var ErrUnauthorized = errors.New("webdav: unauthorized")
+
+// Authenticator validates a request's credentials. Built-in Basic and
+// Digest implementations are provided below; a Server with no Authenticator
+// configured serves every request unauthenticated.
+type Authenticator interface {
+	// Authenticate returns the request's Principal, or ErrUnauthorized (or
+	// a wrapping error) if it could not be authenticated.
+	Authenticate(r *http.Request) (Principal, error)
+
+	// Challenges returns the WWW-Authenticate header value(s) to send back
+	// to the client when Authenticate fails.
+	Challenges() []string
+}
+
+// BasicAuthenticator implements HTTP Basic authentication (RFC 7617).
+type BasicAuthenticator struct {
+	Realm string
+
+	// Validate reports whether user/pass are valid credentials.
+	Validate func(user, pass string) bool
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || a.Validate == nil || !a.Validate(user, pass) {
+		return Principal{}, ErrUnauthorized
+	}
+	return Principal{Name: user}, nil
+}
+
+func (a *BasicAuthenticator) Challenges() []string {
+	return []string{`Basic realm="` + a.Realm + `", charset="UTF-8"`}
+}
+
+// DigestAuthenticator implements HTTP Digest authentication (RFC 7616),
+// using the "auth" quality of protection.
+type DigestAuthenticator struct {
+	Realm string
+
+	// Secret returns the plaintext password for user, or ok=false if the
+	// user is unknown.
+	Secret func(user string) (password string, ok bool)
+
+	// NonceLifetime bounds how long a server nonce remains valid. Defaults
+	// to 5 minutes.
+	NonceLifetime time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]*nonceState
+}
+
+// nonceState tracks a server nonce's expiry and the highest client-supplied
+// nonce-count seen for it, so a captured request can't be replayed with the
+// same nc value. http://www.webdav.org/specs/rfc4918.html (see RFC 7616 §3.3)
+type nonceState struct {
+	expires time.Time
+	maxNC   uint64
+}
+
+var digestFieldRe = regexp.MustCompile(`(\w+)=("[^"]*"|[^,]*)`)
+
+func parseDigestHeader(header string) map[string]string {
+	fields := map[string]string{}
+	for _, m := range digestFieldRe.FindAllStringSubmatch(header, -1) {
+		fields[m[1]] = strings.Trim(m[2], `"`)
+	}
+	return fields
+}
+
+func (a *DigestAuthenticator) nonceLifetime() time.Duration {
+	if a.NonceLifetime > 0 {
+		return a.NonceLifetime
+	}
+	return 5 * time.Minute
+}
+
+func (a *DigestAuthenticator) newNonce() string {
+	var b [16]byte
+	rand.Read(b[:])
+	nonce := hex.EncodeToString(b[:])
+
+	a.mu.Lock()
+	if a.nonces == nil {
+		a.nonces = map[string]*nonceState{}
+	}
+	now := time.Now()
+	for n, state := range a.nonces {
+		if now.After(state.expires) {
+			delete(a.nonces, n)
+		}
+	}
+	a.nonces[nonce] = &nonceState{expires: now.Add(a.nonceLifetime())}
+	a.mu.Unlock()
+
+	return nonce
+}
+
+func (a *DigestAuthenticator) validNonce(nonce string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	state, ok := a.nonces[nonce]
+	return ok && time.Now().Before(state.expires)
+}
+
+// advanceNC reports whether ncHex, the request's "nc" field, is strictly
+// greater than every nc this nonce has seen before, and records it as the
+// new high-water mark. This rejects a replayed request carrying a nonce and
+// nc pair captured from an earlier, legitimate one.
+func (a *DigestAuthenticator) advanceNC(nonce, ncHex string) bool {
+	nc, err := strconv.ParseUint(ncHex, 16, 64)
+	if err != nil || nc == 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	state, ok := a.nonces[nonce]
+	if !ok || nc <= state.maxNC {
+		return false
+	}
+	state.maxNC = nc
+	return true
+}
+
+func (a *DigestAuthenticator) Challenges() []string {
+	return []string{fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", algorithm=MD5`, a.Realm, a.newNonce())}
+}
+
+func (a *DigestAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Digest ") {
+		return Principal{}, ErrUnauthorized
+	}
+	fields := parseDigestHeader(strings.TrimPrefix(header, "Digest "))
+
+	username, nonce, uri, response := fields["username"], fields["nonce"], fields["uri"], fields["response"]
+	if username == "" || nonce == "" || uri == "" || response == "" {
+		return Principal{}, ErrUnauthorized
+	}
+
+	if !a.validNonce(nonce) {
+		return Principal{}, ErrUnauthorized
+	}
+
+	if a.Secret == nil {
+		return Principal{}, ErrUnauthorized
+	}
+	password, ok := a.Secret(username)
+	if !ok {
+		return Principal{}, ErrUnauthorized
+	}
+
+	ha1 := md5Hex(username + ":" + a.Realm + ":" + password)
+	ha2 := md5Hex(r.Method + ":" + uri)
+
+	var want string
+	if qop := fields["qop"]; qop != "" {
+		if !a.advanceNC(nonce, fields["nc"]) {
+			return Principal{}, ErrUnauthorized
+		}
+		want = md5Hex(strings.Join([]string{ha1, nonce, fields["nc"], fields["cnonce"], qop, ha2}, ":"))
+	} else {
+		want = md5Hex(strings.Join([]string{ha1, nonce, ha2}, ":"))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(want), []byte(response)) != 1 {
+		return Principal{}, ErrUnauthorized
+	}
+
+	return Principal{Name: username}, nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
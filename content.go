@@ -0,0 +1,197 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteSeekCloser is the writable counterpart to io.ReadSeeker, used for
+// streaming a PUT body to storage.
+type WriteSeekCloser interface {
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+// WritableFileSystem is an optional FileSystem extension that backends
+// implement to support PUT. The base FileSystem interface only exposes
+// Open, Mkdir and Remove, which is enough for a read-only mount.
+type WritableFileSystem interface {
+	FileSystem
+
+	// Create opens name for writing, creating it if necessary and
+	// truncating any existing content.
+	Create(name string) (WriteSeekCloser, error)
+
+	// Rename atomically moves oldName to newName, replacing newName if it
+	// already exists. It is used to make PUT crash-safe: the request body
+	// is streamed to a temporary name and only swapped into place once
+	// fully and successfully written.
+	Rename(oldName, newName string) error
+}
+
+// ETager is an optional FileSystem extension for backends that can supply a
+// stronger entity tag than the weak ModTime+Size one Server generates by
+// default, e.g. a content hash or a storage-provided version id.
+type ETager interface {
+	ETag(name string) (string, error)
+}
+
+// http://www.webdav.org/specs/rfc4918.html#rfc.section.9.4
+func (s *Server) serveResource(w http.ResponseWriter, r *http.Request) {
+	path := s.url2path(r.URL)
+
+	f, err := s.Fs.Open(path)
+	if err != nil {
+		w.WriteHeader(StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	if fi.IsDir() {
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	// http.ServeContent takes care of Range, If-Modified-Since,
+	// If-Unmodified-Since, If-Match, If-None-Match and HEAD for us, as long
+	// as we set the ETag it should negotiate against beforehand.
+	w.Header().Set("ETag", s.etag(path, fi))
+	http.ServeContent(w, r, path, fi.ModTime(), rs)
+}
+
+// http://www.webdav.org/specs/rfc4918.html#METHOD_PUT
+func (s *Server) doPut(w http.ResponseWriter, r *http.Request) {
+	if s.ReadOnly {
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	path := s.url2path(r.URL)
+	if _, locked := s.isLocked(path, r); locked {
+		writeLockedError(w, s, []string{path})
+		return
+	}
+
+	// RFC 7231 §4.3.4: a PUT that carries Content-Range is almost always a
+	// client mistake (it's not defined what a partial PUT should do), so we
+	// reject it outright rather than guessing.
+	if r.Header.Get("Content-Range") != "" {
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+
+	wfs, ok := s.Fs.(WritableFileSystem)
+	if !ok {
+		w.WriteHeader(StatusNotImplemented)
+		return
+	}
+
+	if s.pathIsDirectory(path) {
+		w.WriteHeader(StatusConflict)
+		return
+	}
+
+	existed := s.pathExists(path)
+	var etag string
+	if existed {
+		if f, err := s.Fs.Open(path); err == nil {
+			if fi, err := f.Stat(); err == nil {
+				etag = s.etag(path, fi)
+			}
+			f.Close()
+		}
+	}
+
+	if !checkWriteConditions(w, r, existed, etag) {
+		return
+	}
+
+	tmp := path + ".webdav-" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".tmp"
+	wc, err := wfs.Create(tmp)
+	if err != nil {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	if _, err := io.Copy(wc, r.Body); err != nil {
+		wc.Close()
+		wfs.Remove(tmp)
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	if err := wc.Close(); err != nil {
+		wfs.Remove(tmp)
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	if err := wfs.Rename(tmp, path); err != nil {
+		wfs.Remove(tmp)
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	if existed {
+		w.WriteHeader(StatusNoContent)
+	} else {
+		w.WriteHeader(StatusCreated)
+	}
+}
+
+// checkWriteConditions enforces If-Match/If-None-Match for PUT, the
+// optimistic-concurrency conditions from RFC 7232. It writes a
+// 412 Precondition Failed and returns false when the request should be
+// aborted.
+func checkWriteConditions(w http.ResponseWriter, r *http.Request, existed bool, etag string) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !existed || !etagMatchesAny(im, etag) {
+			w.WriteHeader(StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if inm == "*" {
+			if existed {
+				w.WriteHeader(StatusPreconditionFailed)
+				return false
+			}
+		} else if existed && etagMatchesAny(inm, etag) {
+			w.WriteHeader(StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	return true
+}
+
+// etagMatchesAny reports whether etag appears in header, a comma-separated
+// If-Match/If-None-Match list. The weak "W/" prefix is ignored, per RFC
+// 7232 §2.3's weak comparison.
+func etagMatchesAny(header, etag string) bool {
+	strip := func(tag string) string { return strings.TrimPrefix(strings.TrimSpace(tag), "W/") }
+	want := strip(etag)
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || strip(tag) == want {
+			return true
+		}
+	}
+	return false
+}
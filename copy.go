@@ -0,0 +1,212 @@
+package webdav
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Copier is an optional FileSystem extension for backends that can clone a
+// resource without reading it through the Server, e.g. via a filesystem
+// reflink or an S3 CopyObject call. When absent, copyResource falls back to
+// Open/Create/io.Copy.
+type Copier interface {
+	Copy(src, dst string) error
+}
+
+// http://www.webdav.org/specs/rfc4918.html#rfc.section.9.8
+func (s *Server) copyResource(w http.ResponseWriter, r *http.Request) (ok, samePath bool) {
+	srcPath := s.url2path(r.URL)
+
+	destPath, status := s.resolveDestination(r)
+	if status != 0 {
+		w.WriteHeader(status)
+		return false, false
+	}
+
+	if destPath == srcPath {
+		w.WriteHeader(StatusForbidden)
+		return false, true
+	}
+
+	// Copying/moving a collection into its own descendant would recurse
+	// forever, since the destination keeps appearing as a new child of the
+	// source on every pass. http://www.webdav.org/specs/rfc4918.html#rfc.section.9.8
+	if lockCovers(srcPath, false, destPath) {
+		w.WriteHeader(StatusBadRequest)
+		return false, false
+	}
+
+	if !s.pathExists(srcPath) {
+		w.WriteHeader(StatusNotFound)
+		return false, false
+	}
+
+	if _, locked := s.isLocked(destPath, r); locked {
+		writeLockedError(w, s, []string{destPath})
+		return false, false
+	}
+
+	destExisted := s.pathExists(destPath)
+	if destExisted && r.Header.Get("Overwrite") == "F" {
+		w.WriteHeader(StatusPreconditionFailed)
+		return false, false
+	}
+
+	depth := r.Header.Get("Depth")
+	switch depth {
+	case "", "infinity":
+		depth = "infinity"
+	case "0":
+	default:
+		w.WriteHeader(StatusBadRequest)
+		return false, false
+	}
+
+	if destExisted {
+		if !s.deleteResource(destPath, w, r, false) {
+			// deleteResource already wrote the failure response.
+			return false, false
+		}
+	}
+
+	errs := map[string]int{}
+	s.copyTree(srcPath, destPath, depth == "infinity", errs, r)
+
+	if len(errs) > 0 {
+		responses := make([]msResponse, 0, len(errs))
+		for p, status := range errs {
+			responses = append(responses, msResponse{href: s.path2url(p).String(), status: status})
+		}
+		writeMultiStatus(w, responses)
+		return false, false
+	}
+
+	if destExisted {
+		w.WriteHeader(StatusNoContent)
+	} else {
+		w.WriteHeader(StatusCreated)
+	}
+	return true, false
+}
+
+// resolveDestination parses and validates r's Destination header, returning
+// the server-relative path it names. A non-zero status means resolution
+// failed and that status has already been chosen for the response.
+func (s *Server) resolveDestination(r *http.Request) (path string, status int) {
+	header := r.Header.Get("Destination")
+	if header == "" {
+		return "", StatusBadRequest
+	}
+
+	dest, err := url.Parse(header)
+	if err != nil {
+		return "", StatusBadRequest
+	}
+
+	// Destination may be an absolute URI; reject anything that doesn't
+	// resolve to this server, since we have no way to proxy a cross-server
+	// copy/move. http://www.webdav.org/specs/rfc4918.html#rfc.section.9.8.3
+	if dest.Host != "" && !strings.EqualFold(dest.Host, r.Host) {
+		return "", StatusBadGateway
+	}
+
+	return s.url2path(dest), 0
+}
+
+// copyTree copies the resource at src to dst, recursing into collections
+// when recursive is true. Per-path failures are recorded in errs rather
+// than aborting the whole operation, so a single bad descendant doesn't
+// fail an otherwise successful recursive copy.
+func (s *Server) copyTree(src, dst string, recursive bool, errs map[string]int, r *http.Request) {
+	f, err := s.Fs.Open(src)
+	if err != nil {
+		errs[src] = StatusNotFound
+		return
+	}
+	fi, err := f.Stat()
+	f.Close()
+	if err != nil {
+		errs[src] = StatusInternalServerError
+		return
+	}
+
+	if fi.IsDir() {
+		if err := s.Fs.Mkdir(dst); err != nil {
+			errs[dst] = StatusConflict
+			return
+		}
+		s.copyDeadProps(src, dst)
+
+		if !recursive {
+			return
+		}
+
+		prefix := strings.Trim(src, "/") + "/"
+		for _, child := range s.childPaths(src) {
+			childDst := strings.Trim(dst, "/") + "/" + strings.TrimPrefix(child, prefix)
+
+			if _, locked := s.isLocked(childDst, r); locked {
+				errs[childDst] = StatusLocked
+				continue
+			}
+
+			s.copyTree(child, childDst, true, errs, r)
+		}
+		return
+	}
+
+	if err := s.copyFile(src, dst); err != nil {
+		errs[dst] = StatusInternalServerError
+		return
+	}
+	s.copyDeadProps(src, dst)
+}
+
+// copyFile copies a single non-collection resource, preferring a Copier
+// implementation on the FileSystem over the Open/Create/io.Copy fallback.
+func (s *Server) copyFile(src, dst string) error {
+	if c, ok := s.Fs.(Copier); ok {
+		return c.Copy(src, dst)
+	}
+
+	wfs, ok := s.Fs.(WritableFileSystem)
+	if !ok {
+		return errors.New("webdav: filesystem does not support writes")
+	}
+
+	sf, err := s.Fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	df, err := wfs.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
+		return err
+	}
+	return df.Close()
+}
+
+// copyDeadProps carries dead properties from src over to dst, best-effort:
+// a property store error here shouldn't fail a copy whose content already
+// succeeded.
+func (s *Server) copyDeadProps(src, dst string) {
+	props, err := s.deadProps().GetProps(src, nil)
+	if err != nil || len(props) == 0 {
+		return
+	}
+
+	set := make([]Property, 0, len(props))
+	for _, p := range props {
+		set = append(set, p)
+	}
+	s.deadProps().PatchProps(dst, set, nil)
+}
@@ -0,0 +1,252 @@
+package webdav
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFS is a minimal in-memory FileSystem fixture for exercising COPY/MOVE
+// without touching disk. Paths are stored without leading slashes, matching
+// what url2path hands to FileSystem methods.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string][]byte{}, dirs: map[string]bool{}}
+}
+
+func (fs *memFS) Open(name string) (File, error) {
+	name = strings.Trim(name, "/")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if name == "" || fs.dirs[name] {
+		return &memFile{fs: fs, name: name, isDir: true}, nil
+	}
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &memFile{fs: fs, name: name, r: bytes.NewReader(data)}, nil
+}
+
+func (fs *memFS) Mkdir(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.dirs[strings.Trim(name, "/")] = true
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	name = strings.Trim(name, "/")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.files, name)
+	delete(fs.dirs, name)
+	return nil
+}
+
+func (fs *memFS) Create(name string) (WriteSeekCloser, error) {
+	return &memWriter{fs: fs, name: strings.Trim(name, "/")}, nil
+}
+
+func (fs *memFS) Rename(oldName, newName string) error {
+	oldName, newName = strings.Trim(oldName, "/"), strings.Trim(newName, "/")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, ok := fs.files[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	fs.files[newName] = data
+	delete(fs.files, oldName)
+	return nil
+}
+
+type memFile struct {
+	fs    *memFS
+	name  string
+	isDir bool
+	r     *bytes.Reader
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.r.Read(p)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.isDir {
+		return memFileInfo{name: f.name, isDir: true}, nil
+	}
+	f.fs.mu.Lock()
+	size := len(f.fs.files[f.name])
+	f.fs.mu.Unlock()
+	return memFileInfo{name: f.name, size: int64(size)}, nil
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	prefix := f.name
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	var infos []os.FileInfo
+	for name := range f.fs.files {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && !strings.Contains(rest, "/") {
+			infos = append(infos, memFileInfo{name: rest})
+		}
+	}
+	for name := range f.fs.dirs {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && rest != "" && !strings.Contains(rest, "/") {
+			infos = append(infos, memFileInfo{name: rest, isDir: true})
+		}
+	}
+	return infos, nil
+}
+
+type memWriter struct {
+	fs   *memFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error)    { return w.buf.Write(p) }
+func (w *memWriter) Seek(int64, int) (int64, error) { return 0, nil }
+func (w *memWriter) Close() error {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.fs.files[w.name] = append([]byte(nil), w.buf.Bytes()...)
+	return nil
+}
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi memFileInfo) Sys() any           { return nil }
+
+func TestDoCopyOverwriteForbidden(t *testing.T) {
+	fs := newMemFS()
+	fs.files["a.txt"] = []byte("hello")
+	fs.files["b.txt"] = []byte("world")
+	s := &Server{Fs: fs}
+
+	r := httptest.NewRequest("COPY", "/a.txt", nil)
+	r.Header.Set("Destination", "/b.txt")
+	r.Header.Set("Overwrite", "F")
+	rec := httptest.NewRecorder()
+
+	s.doCopy(rec, r)
+
+	if rec.Code != StatusPreconditionFailed {
+		t.Errorf("status = %d, want %d", rec.Code, StatusPreconditionFailed)
+	}
+	if string(fs.files["b.txt"]) != "world" {
+		t.Errorf("destination was overwritten despite Overwrite: F")
+	}
+}
+
+func TestDoCopyDepthZeroCollection(t *testing.T) {
+	fs := newMemFS()
+	fs.dirs["src"] = true
+	fs.files["src/child.txt"] = []byte("child")
+	s := &Server{Fs: fs}
+
+	r := httptest.NewRequest("COPY", "/src", nil)
+	r.Header.Set("Destination", "/dst")
+	r.Header.Set("Depth", "0")
+	rec := httptest.NewRecorder()
+
+	s.doCopy(rec, r)
+
+	if rec.Code != StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, StatusCreated)
+	}
+	if !fs.dirs["dst"] {
+		t.Errorf("destination collection was not created")
+	}
+	if _, ok := fs.files["dst/child.txt"]; ok {
+		t.Errorf("Depth: 0 copy should not have recursed into children")
+	}
+}
+
+func TestDoMoveRelocatesResource(t *testing.T) {
+	fs := newMemFS()
+	fs.files["a.txt"] = []byte("hello")
+	s := &Server{Fs: fs}
+
+	r := httptest.NewRequest("MOVE", "/a.txt", nil)
+	r.Header.Set("Destination", "/b.txt")
+	rec := httptest.NewRecorder()
+
+	s.doMove(rec, r)
+
+	if rec.Code != StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, StatusCreated)
+	}
+	if _, ok := fs.files["a.txt"]; ok {
+		t.Errorf("source still exists after MOVE")
+	}
+	if string(fs.files["b.txt"]) != "hello" {
+		t.Errorf("destination content = %q, want %q", fs.files["b.txt"], "hello")
+	}
+}
+
+func TestDoCopyRecursivePartialFailureReports207(t *testing.T) {
+	fs := newMemFS()
+	fs.dirs["src"] = true
+	fs.files["src/ok.txt"] = []byte("ok")
+	s := &Server{Fs: fs}
+
+	// Lock the destination a recursive copy would land on, so that one
+	// child fails while the rest of the tree still succeeds.
+	if _, err := s.locks().Create(time.Now(), LockDetails{Root: "dst/ok.txt"}); err != nil {
+		t.Fatalf("seeding lock: %v", err)
+	}
+
+	r := httptest.NewRequest("COPY", "/src", nil)
+	r.Header.Set("Destination", "/dst")
+	rec := httptest.NewRecorder()
+
+	s.doCopy(rec, r)
+
+	if rec.Code != StatusMulti {
+		t.Fatalf("status = %d, want %d", rec.Code, StatusMulti)
+	}
+	if !strings.Contains(rec.Body.String(), "/dst/ok.txt") {
+		t.Errorf("multistatus body missing failed child href: %s", rec.Body.String())
+	}
+}
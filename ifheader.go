@@ -0,0 +1,121 @@
+package webdav
+
+import (
+	"errors"
+	"strings"
+)
+
+// ifList is a single List production of the HTTP "If" header, optionally
+// scoped to a Resource-Tag.
+// http://www.webdav.org/specs/rfc4918.html#rfc.section.10.4.1
+type ifList struct {
+	// resourceTag is the Coded-URL preceding the list in a Tagged-list,
+	// or "" for a No-tag-list (which applies to the Request-URI).
+	resourceTag string
+	conditions  []Condition
+}
+
+// parseIfHeader parses the value of an HTTP "If" header into its lists of
+// conditions. It handles both the tagged-list and no-tag-list productions,
+// including "Not" negation and "[...]" entity-tag conditions.
+func parseIfHeader(header string) ([]ifList, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil, nil
+	}
+
+	var lists []ifList
+	var tag string
+
+	i, n := 0, len(header)
+	skipSpace := func() {
+		for i < n && (header[i] == ' ' || header[i] == '\t') {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i >= n {
+			return lists, nil
+		}
+
+		switch header[i] {
+		case '<':
+			end := strings.IndexByte(header[i:], '>')
+			if end < 0 {
+				return nil, errors.New("webdav: malformed If header: unterminated Resource-Tag")
+			}
+			tag = header[i+1 : i+end]
+			i += end + 1
+
+		case '(':
+			i++
+			conds, consumed, err := parseIfList(header[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += consumed
+			lists = append(lists, ifList{resourceTag: tag, conditions: conds})
+
+		default:
+			return nil, errors.New("webdav: malformed If header: expected '<' or '('")
+		}
+	}
+}
+
+// parseIfList parses the conditions inside a single List production (the
+// text between "(" and its matching ")"), given s starting just past the
+// opening paren. It returns the conditions and how many bytes of s were
+// consumed, including the closing paren.
+func parseIfList(s string) ([]Condition, int, error) {
+	var conds []Condition
+	i, n := 0, len(s)
+	skipSpace := func() {
+		for i < n && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i >= n {
+			return nil, 0, errors.New("webdav: malformed If header: unterminated list")
+		}
+		if s[i] == ')' {
+			return conds, i + 1, nil
+		}
+
+		var c Condition
+		if strings.HasPrefix(s[i:], "Not") {
+			c.Not = true
+			i += len("Not")
+			skipSpace()
+		}
+
+		if i >= n {
+			return nil, 0, errors.New("webdav: malformed If header: expected condition")
+		}
+
+		switch s[i] {
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return nil, 0, errors.New("webdav: malformed If header: unterminated state-token")
+			}
+			c.Token = s[i+1 : i+end]
+			i += end + 1
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, 0, errors.New("webdav: malformed If header: unterminated entity-tag")
+			}
+			c.ETag = strings.Trim(s[i+1:i+end], `"`)
+			i += end + 1
+		default:
+			return nil, 0, errors.New("webdav: malformed If header: expected state-token or entity-tag")
+		}
+
+		conds = append(conds, c)
+	}
+}
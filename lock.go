@@ -0,0 +1,564 @@
+package webdav
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockDetails describes a single WebDAV lock, as created by a LOCK request.
+type LockDetails struct {
+	// Root is the locked path, relative to the Server's FileSystem.
+	Root string
+
+	// Duration is how long the lock is valid for from the moment it was
+	// created or last refreshed. A duration of 0 is treated as infinite.
+	Duration time.Duration
+
+	// OwnerXML is the verbatim contents of the request's <D:owner> element,
+	// or empty if the client did not supply one.
+	OwnerXML string
+
+	// Shared is true for a shared lock, false for an exclusive lock.
+	Shared bool
+
+	// ZeroDepth is true when the lock was requested with "Depth: 0", i.e.
+	// it only covers Root and not its descendants.
+	ZeroDepth bool
+}
+
+// Condition is a single term of the HTTP "If" header, as used to prove
+// ownership of a lock. http://www.webdav.org/specs/rfc4918.html#rfc.section.10.4.1
+type Condition struct {
+	Not   bool
+	Token string // a state-token, e.g. "opaquelocktoken:1234"
+	ETag  string // an entity-tag, from a "[...]" condition
+}
+
+// ActiveLock pairs a lock's token with its details, as returned by
+// LockSystem.Lookup for rendering <D:lockdiscovery>.
+type ActiveLock struct {
+	Token   string
+	Details LockDetails
+}
+
+// ErrLocked is returned by LockSystem.Create when the requested lock
+// conflicts with an existing one.
+var ErrLocked = errors.New("webdav: locked")
+
+// ErrNoSuchLock is returned by LockSystem.Refresh and Unlock when the given
+// token does not identify a current lock.
+var ErrNoSuchLock = errors.New("webdav: no such lock")
+
+// LockSystem is the pluggable backend behind LOCK/UNLOCK and the 423
+// enforcement on every other write method. Implementations must be safe for
+// concurrent use; the built-in MemLS is in-memory, but Redis- or
+// database-backed implementations are straightforward to add.
+type LockSystem interface {
+	// Create makes a new lock rooted at details.Root and returns its token,
+	// formatted as an opaquelocktoken URI. It fails with ErrLocked if the
+	// new lock and an existing one cover each other's root and at least one
+	// of the two is exclusive; shared locks coexist.
+	Create(now time.Time, details LockDetails) (token string, err error)
+
+	// Refresh extends the timeout of the lock identified by token and
+	// returns its (possibly stale) details.
+	Refresh(now time.Time, token string, duration time.Duration) (LockDetails, error)
+
+	// Unlock releases the lock identified by token.
+	Unlock(now time.Time, token string) error
+
+	// Confirm reports the token of a lock covering path that is not
+	// satisfied by any of conditions, or "" if path is free to modify.
+	// A condition satisfies a lock when it carries that lock's token or
+	// path's current etag; a Not condition inverts that match. etag is
+	// path's current entity tag, or "" if path has none (e.g. it doesn't
+	// exist or is a collection).
+	Confirm(now time.Time, path, etag string, conditions ...Condition) (conflictToken string)
+
+	// Lookup returns every non-expired lock that covers path, for
+	// rendering <D:lockdiscovery>.
+	Lookup(now time.Time, path string) []ActiveLock
+}
+
+// MemLS is an in-memory LockSystem with TTL-based expiry. A background
+// sweeper periodically drops expired locks so long-running servers don't
+// accumulate garbage.
+type MemLS struct {
+	mu      sync.Mutex
+	byToken map[string]*memLock
+
+	stop chan struct{}
+}
+
+type memLock struct {
+	token   string
+	details LockDetails
+	expiry  time.Time // zero means it never expires
+}
+
+// NewMemLS returns a ready to use MemLS and starts its background sweeper,
+// which runs every sweepInterval. Callers that want to stop the sweeper
+// (e.g. in tests) should call Close.
+func NewMemLS(sweepInterval time.Duration) *MemLS {
+	if sweepInterval <= 0 {
+		sweepInterval = time.Minute
+	}
+
+	ls := &MemLS{
+		byToken: map[string]*memLock{},
+		stop:    make(chan struct{}),
+	}
+
+	go ls.sweep(sweepInterval)
+
+	return ls
+}
+
+// Close stops the background sweeper. It is safe to call more than once.
+func (ls *MemLS) Close() {
+	select {
+	case <-ls.stop:
+	default:
+		close(ls.stop)
+	}
+}
+
+func (ls *MemLS) sweep(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ls.stop:
+			return
+		case now := <-t.C:
+			ls.mu.Lock()
+			for token, l := range ls.byToken {
+				if !l.expiry.IsZero() && now.After(l.expiry) {
+					delete(ls.byToken, token)
+				}
+			}
+			ls.mu.Unlock()
+		}
+	}
+}
+
+// lockCovers reports whether a lock rooted at root (with the given zero-depth
+// flag) covers path.
+func lockCovers(root string, zeroDepth bool, path string) bool {
+	root = strings.Trim(root, "/")
+	path = strings.Trim(path, "/")
+
+	if root == path {
+		return true
+	}
+	if zeroDepth {
+		return false
+	}
+	return strings.HasPrefix(path, root+"/")
+}
+
+// conflicts reports an existing lock that overlaps root (per lockCovers)
+// and cannot coexist with a new lock of the given shared-ness. Per RFC 4918
+// §7, two shared locks never conflict; anything involving an exclusive
+// lock does.
+func (ls *MemLS) conflicts(now time.Time, root string, zeroDepth, shared bool) *memLock {
+	for _, l := range ls.byToken {
+		if !l.expiry.IsZero() && now.After(l.expiry) {
+			continue
+		}
+		if shared && l.details.Shared {
+			continue
+		}
+		if lockCovers(l.details.Root, l.details.ZeroDepth, root) || lockCovers(root, zeroDepth, l.details.Root) {
+			return l
+		}
+	}
+	return nil
+}
+
+func (ls *MemLS) Create(now time.Time, details LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if c := ls.conflicts(now, details.Root, details.ZeroDepth, details.Shared); c != nil {
+		return "", ErrLocked
+	}
+
+	token := newLockToken()
+	l := &memLock{token: token, details: details}
+	if details.Duration > 0 {
+		l.expiry = now.Add(details.Duration)
+	}
+	ls.byToken[token] = l
+
+	return token, nil
+}
+
+func (ls *MemLS) Refresh(now time.Time, token string, duration time.Duration) (LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.byToken[token]
+	if !ok || (!l.expiry.IsZero() && now.After(l.expiry)) {
+		return LockDetails{}, ErrNoSuchLock
+	}
+
+	l.details.Duration = duration
+	if duration > 0 {
+		l.expiry = now.Add(duration)
+	} else {
+		l.expiry = time.Time{}
+	}
+
+	return l.details, nil
+}
+
+func (ls *MemLS) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.byToken[token]
+	if !ok || (!l.expiry.IsZero() && now.After(l.expiry)) {
+		return ErrNoSuchLock
+	}
+
+	delete(ls.byToken, token)
+	return nil
+}
+
+func (ls *MemLS) Confirm(now time.Time, path, etag string, conditions ...Condition) string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for token, l := range ls.byToken {
+		if !l.expiry.IsZero() && now.After(l.expiry) {
+			continue
+		}
+		if !lockCovers(l.details.Root, l.details.ZeroDepth, path) {
+			continue
+		}
+
+		if !conditionsSatisfy(conditions, token, etag) {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// conditionsSatisfy reports whether any condition proves ownership of
+// token, or matches etag, taking each condition's Not negation into
+// account. Entity-tags are compared without their quotes and "W/" prefix,
+// since parseIfHeader strips the quotes from a "[...]" condition.
+func conditionsSatisfy(conditions []Condition, token, etag string) bool {
+	bare := func(tag string) string { return strings.Trim(strings.TrimPrefix(tag, "W/"), `"`) }
+	for _, c := range conditions {
+		match := (c.Token != "" && c.Token == token) || (etag != "" && c.ETag != "" && bare(c.ETag) == bare(etag))
+		if c.Not {
+			match = !match
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func (ls *MemLS) Lookup(now time.Time, path string) []ActiveLock {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var active []ActiveLock
+	for token, l := range ls.byToken {
+		if !l.expiry.IsZero() && now.After(l.expiry) {
+			continue
+		}
+		if lockCovers(l.details.Root, l.details.ZeroDepth, path) {
+			active = append(active, ActiveLock{Token: token, Details: l.details})
+		}
+	}
+	return active
+}
+
+// newLockToken returns a fresh RFC 4918 §6.4 opaquelocktoken URI.
+func newLockToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (s *Server) locks() LockSystem {
+	if s.Locks == nil {
+		s.Locks = NewMemLS(time.Minute)
+	}
+	return s.Locks
+}
+
+// ifConditions parses r's "If" header and returns the conditions that apply
+// to path: those from untagged lists, plus those from tagged lists whose
+// resource-tag resolves to path's URL.
+func (s *Server) ifConditions(r *http.Request, path string) []Condition {
+	lists, err := parseIfHeader(r.Header.Get("If"))
+	if err != nil {
+		return nil
+	}
+
+	var conditions []Condition
+	for _, l := range lists {
+		if l.resourceTag != "" {
+			// A Resource-Tag may be an absolute URI or an absolute path; only
+			// its path identifies the resource.
+			u, err := url.Parse(l.resourceTag)
+			if err != nil || strings.Trim(u.Path, "/") != strings.Trim(path, "/") {
+				continue
+			}
+		}
+		conditions = append(conditions, l.conditions...)
+	}
+	return conditions
+}
+
+// isLocked reports whether path is locked in a way that is not satisfied by
+// r's "If" header, returning the token of the blocking lock.
+func (s *Server) isLocked(path string, r *http.Request) (token string, locked bool) {
+	token = s.locks().Confirm(time.Now(), path, s.currentETag(path), s.ifConditions(r, path)...)
+	return token, token != ""
+}
+
+// currentETag returns path's current entity tag, or "" if path doesn't
+// exist or is a collection (neither has one).
+func (s *Server) currentETag(path string) string {
+	f, err := s.Fs.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return ""
+	}
+	return s.etag(path, fi)
+}
+
+// isLockedRequest reports whether the resource targeted by r is locked.
+func (s *Server) isLockedRequest(r *http.Request) bool {
+	_, locked := s.isLocked(s.url2path(r.URL), r)
+	return locked
+}
+
+// writeLockedError writes a 423 Locked response with the
+// <D:error><D:lock-token-submitted> body RFC 4918 §9.10.9 requires, listing
+// the hrefs of every path that blocked the request.
+func writeLockedError(w http.ResponseWriter, s *Server, paths []string) {
+	buf := []byte(xmlHeader + `<D:error xmlns:D="DAV:"><D:lock-token-submitted>`)
+	for _, p := range paths {
+		buf = append(buf, []byte(`<D:href>`)...)
+		buf = append(buf, []byte(htmlEscapeHref(s.path2url(p).String()))...)
+		buf = append(buf, []byte(`</D:href>`)...)
+	}
+	buf = append(buf, []byte(`</D:lock-token-submitted></D:error>`)...)
+
+	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	w.WriteHeader(StatusLocked)
+	w.Write(buf)
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="utf-8"?>`
+
+func htmlEscapeHref(href string) string {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	return r.Replace(href)
+}
+
+// http://www.webdav.org/specs/rfc4918.html#METHOD_LOCK
+func (s *Server) doLock(w http.ResponseWriter, r *http.Request) {
+	if s.ReadOnly {
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	path := s.url2path(r.URL)
+	now := time.Now()
+	duration := parseTimeout(r.Header.Get("Timeout"))
+
+	if r.ContentLength == 0 {
+		// Refresh: the lock token comes from the If header, not the body. The
+		// header can carry several conditions (e.g. a Not etag alongside the
+		// token), so scan for the first one that actually names a lock
+		// rather than assuming it's always the first condition parsed.
+		var token string
+		for _, c := range s.ifConditions(r, path) {
+			if !c.Not && c.Token != "" {
+				token = c.Token
+				break
+			}
+		}
+		if token == "" {
+			w.WriteHeader(StatusBadRequest)
+			return
+		}
+
+		details, err := s.locks().Refresh(now, token, duration)
+		if err != nil {
+			w.WriteHeader(StatusPreconditionFailed)
+			return
+		}
+
+		w.Header().Set("Lock-Token", "<"+token+">")
+		writeLockDiscovery(w, StatusOK, token, details)
+		return
+	}
+
+	root, err := NodeFromXml(r.Body)
+	if err != nil {
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+
+	details := LockDetails{Root: path, Duration: duration, ZeroDepth: r.Header.Get("Depth") == "0"}
+	for _, scope := range root.GetChildrens("lockscope") {
+		details.Shared = scope.HasChildren("shared")
+	}
+	for _, owner := range root.GetChildrens("owner") {
+		details.OwnerXML = string(owner.InnerXml())
+	}
+
+	existed := s.pathExists(path)
+
+	token, err := s.locks().Create(now, details)
+	if err == ErrLocked {
+		writeLockedError(w, s, []string{path})
+		return
+	} else if err != nil {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	if !existed {
+		// A LOCK on a non-existent resource creates it as an empty resource;
+		// it stays in place after the lock expires or is unlocked.
+		// http://www.webdav.org/specs/rfc4918.html#rfc.section.7.3
+		if wfs, ok := s.Fs.(WritableFileSystem); ok {
+			if wc, err := wfs.Create(path); err == nil {
+				wc.Close()
+			}
+		}
+	}
+
+	w.Header().Set("Lock-Token", "<"+token+">")
+	if existed {
+		writeLockDiscovery(w, StatusOK, token, details)
+	} else {
+		writeLockDiscovery(w, StatusCreated, token, details)
+	}
+}
+
+// http://www.webdav.org/specs/rfc4918.html#METHOD_UNLOCK
+func (s *Server) doUnlock(w http.ResponseWriter, r *http.Request) {
+	if s.ReadOnly {
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	token := strings.Trim(r.Header.Get("Lock-Token"), "<>")
+	if token == "" {
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+
+	if err := s.locks().Unlock(time.Now(), token); err != nil {
+		w.WriteHeader(StatusConflict)
+		return
+	}
+
+	w.WriteHeader(StatusNoContent)
+}
+
+// writeLockDiscovery renders the <D:prop><D:lockdiscovery> body that LOCK
+// and LOCK-refresh responses return.
+func writeLockDiscovery(w http.ResponseWriter, status int, token string, details LockDetails) {
+	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	w.WriteHeader(status)
+
+	buf := []byte(xmlHeader + `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`)
+	buf = append(buf, []byte(activeLockXML(ActiveLock{Token: token, Details: details}))...)
+	buf = append(buf, []byte(`</D:lockdiscovery></D:prop>`)...)
+
+	w.Write(buf)
+}
+
+// activeLockXML renders a single <D:activelock> element, as used both by
+// LOCK responses and by the lockdiscovery live property.
+func activeLockXML(l ActiveLock) string {
+	scope := "<D:exclusive/>"
+	if l.Details.Shared {
+		scope = "<D:shared/>"
+	}
+
+	depth := "infinity"
+	if l.Details.ZeroDepth {
+		depth = "0"
+	}
+
+	timeout := "Infinite"
+	if l.Details.Duration > 0 {
+		timeout = "Second-" + strconv.Itoa(int(l.Details.Duration/time.Second))
+	}
+
+	owner := ""
+	if l.Details.OwnerXML != "" {
+		owner = "<D:owner>" + l.Details.OwnerXML + "</D:owner>"
+	}
+
+	return "<D:activelock>" +
+		"<D:locktype><D:write/></D:locktype>" +
+		"<D:lockscope>" + scope + "</D:lockscope>" +
+		"<D:depth>" + depth + "</D:depth>" +
+		owner +
+		"<D:timeout>" + timeout + "</D:timeout>" +
+		"<D:locktoken><D:href>" + l.Token + "</D:href></D:locktoken>" +
+		"</D:activelock>"
+}
+
+// parseTimeout parses a "Timeout" request header (a comma-separated list of
+// "Second-NN" or "Infinite") and returns the first value the server is
+// willing to honor. An absent or unparseable header yields the default.
+func parseTimeout(header string) time.Duration {
+	const (
+		defaultTimeout = 5 * time.Minute
+		maxTimeout     = time.Hour
+	)
+
+	if header == "" {
+		return defaultTimeout
+	}
+
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "Infinite" {
+			return 0
+		}
+		if n, ok := strings.CutPrefix(v, "Second-"); ok {
+			if secs, err := strconv.Atoi(n); err == nil {
+				d := time.Duration(secs) * time.Second
+				if d > maxTimeout {
+					d = maxTimeout
+				}
+				return d
+			}
+		}
+	}
+
+	return defaultTimeout
+}
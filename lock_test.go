@@ -0,0 +1,177 @@
+package webdav
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemLSConflicts(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name              string
+		existing          LockDetails
+		root              string
+		zeroDepth, shared bool
+		wantErr           error
+	}{
+		{
+			name:     "two shared locks on the same path do not conflict",
+			existing: LockDetails{Root: "a", Shared: true},
+			root:     "a", shared: true,
+			wantErr: nil,
+		},
+		{
+			name:     "shared lock conflicts with an exclusive request",
+			existing: LockDetails{Root: "a", Shared: true},
+			root:     "a", shared: false,
+			wantErr: ErrLocked,
+		},
+		{
+			name:     "exclusive lock conflicts with a shared request",
+			existing: LockDetails{Root: "a", Shared: false},
+			root:     "a", shared: true,
+			wantErr: ErrLocked,
+		},
+		{
+			name:     "two exclusive locks on the same path conflict",
+			existing: LockDetails{Root: "a", Shared: false},
+			root:     "a", shared: false,
+			wantErr: ErrLocked,
+		},
+		{
+			name:     "zero-depth lock on a parent does not cover a child",
+			existing: LockDetails{Root: "a", ZeroDepth: true, Shared: false},
+			root:     "a/b", shared: false,
+			wantErr: nil,
+		},
+		{
+			name:     "full-depth lock on a parent covers a child",
+			existing: LockDetails{Root: "a", Shared: false},
+			root:     "a/b", shared: false,
+			wantErr: ErrLocked,
+		},
+		{
+			name:     "locks on unrelated paths do not conflict",
+			existing: LockDetails{Root: "a", Shared: false},
+			root:     "b", shared: false,
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls := &MemLS{byToken: map[string]*memLock{}}
+			if _, err := ls.Create(now, tt.existing); err != nil {
+				t.Fatalf("seeding existing lock: %v", err)
+			}
+
+			_, err := ls.Create(now, LockDetails{Root: tt.root, ZeroDepth: tt.zeroDepth, Shared: tt.shared})
+			if err != tt.wantErr {
+				t.Errorf("Create() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemLSConfirm(t *testing.T) {
+	now := time.Now()
+	ls := &MemLS{byToken: map[string]*memLock{}}
+
+	token, err := ls.Create(now, LockDetails{Root: "a"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		etag       string
+		conditions []Condition
+		wantBlock  bool
+	}{
+		{name: "no conditions leaves the lock unsatisfied", wantBlock: true},
+		{name: "the lock's own token satisfies it", conditions: []Condition{{Token: token}}, wantBlock: false},
+		{name: "a different token does not satisfy it", conditions: []Condition{{Token: "opaquelocktoken:other"}}, wantBlock: true},
+		{name: "a matching etag satisfies it", etag: `"v1"`, conditions: []Condition{{ETag: `"v1"`}}, wantBlock: false},
+		{name: "Not inverts a token match into a conflict", conditions: []Condition{{Not: true, Token: token}}, wantBlock: true},
+		{name: "Not on a non-matching token is satisfied", conditions: []Condition{{Not: true, Token: "opaquelocktoken:other"}}, wantBlock: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ls.Confirm(now, "a", tt.etag, tt.conditions...)
+			if blocked := got != ""; blocked != tt.wantBlock {
+				t.Errorf("Confirm() blocked = %v (token %q), want %v", blocked, got, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestParseIfHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []ifList
+		wantErr bool
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   nil,
+		},
+		{
+			name:   "no-tag-list with a single state-token",
+			header: "(<opaquelocktoken:1234>)",
+			want:   []ifList{{conditions: []Condition{{Token: "opaquelocktoken:1234"}}}},
+		},
+		{
+			name:   "no-tag-list with a Not condition",
+			header: "(Not <opaquelocktoken:1234>)",
+			want:   []ifList{{conditions: []Condition{{Not: true, Token: "opaquelocktoken:1234"}}}},
+		},
+		{
+			name:   "no-tag-list with an entity-tag",
+			header: `(["etag1"])`,
+			want:   []ifList{{conditions: []Condition{{ETag: "etag1"}}}},
+		},
+		{
+			name:   "tagged list scopes its conditions to the resource",
+			header: "</res1> (<opaquelocktoken:1234>)",
+			want:   []ifList{{resourceTag: "/res1", conditions: []Condition{{Token: "opaquelocktoken:1234"}}}},
+		},
+		{
+			name:    "malformed header with no leading token or list",
+			header:  "garbage",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIfHeader(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIfHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseIfHeader() = %#v, want %#v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].resourceTag != tt.want[i].resourceTag {
+					t.Errorf("list %d resourceTag = %q, want %q", i, got[i].resourceTag, tt.want[i].resourceTag)
+				}
+				if len(got[i].conditions) != len(tt.want[i].conditions) {
+					t.Fatalf("list %d conditions = %#v, want %#v", i, got[i].conditions, tt.want[i].conditions)
+				}
+				for j := range got[i].conditions {
+					if got[i].conditions[j] != tt.want[i].conditions[j] {
+						t.Errorf("list %d condition %d = %#v, want %#v", i, j, got[i].conditions[j], tt.want[i].conditions[j])
+					}
+				}
+			}
+		})
+	}
+}
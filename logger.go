@@ -0,0 +1,77 @@
+package webdav
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Logger is the structured, leveled logging interface Server uses for every
+// diagnostic and access-log message. kv is an alternating key/value list,
+// exactly like log/slog's shorthand logging methods, so a Logger is trivial
+// to back with an slog.Handler (see NewSlogLogger).
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+
+	// With returns a Logger that prepends kv to every subsequent call,
+	// used to attach per-request attributes (method, URL, remote, ...).
+	With(kv ...any) Logger
+}
+
+// slogLogger adapts an *slog.Logger to the Logger interface.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a Logger backed by the given slog.Handler. A Server
+// with no Logger configured uses one wrapping slog.Default()'s handler.
+func NewSlogLogger(h slog.Handler) Logger {
+	return &slogLogger{l: slog.New(h)}
+}
+
+func (s *slogLogger) Debug(msg string, kv ...any) { s.l.Debug(msg, kv...) }
+func (s *slogLogger) Info(msg string, kv ...any)  { s.l.Info(msg, kv...) }
+func (s *slogLogger) Warn(msg string, kv ...any)  { s.l.Warn(msg, kv...) }
+func (s *slogLogger) Error(msg string, kv ...any) { s.l.Error(msg, kv...) }
+
+func (s *slogLogger) With(kv ...any) Logger {
+	return &slogLogger{l: s.l.With(kv...)}
+}
+
+var defaultLogger Logger = NewSlogLogger(slog.Default().Handler())
+
+// logger returns the Logger to use for the current request: the one bound
+// by ServeHTTP if this Server value is request-scoped, else the configured
+// Server.Logger, else the package default.
+func (s *Server) logger() Logger {
+	if s.reqLogger != nil {
+		return s.reqLogger
+	}
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return defaultLogger
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// written, for the access-log summary at the end of ServeHTTP.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
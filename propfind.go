@@ -0,0 +1,412 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Property represents a single WebDAV property, as defined in RFC 4918.
+// http://www.webdav.org/specs/rfc4918.html#dav.properties
+type Property struct {
+	// XMLName is the fully qualified name of the property.
+	XMLName xml.Name
+
+	// Lang is an optional xml:lang attribute.
+	Lang string `xml:"xml:lang,attr,omitempty"`
+
+	// InnerXML contains the XML representation of the property value, as
+	// found between the opening and closing tags of XMLName. It is used
+	// verbatim, without any unmarshalling or validation.
+	InnerXML []byte `xml:",innerxml"`
+}
+
+// DeadPropertyStore persists arbitrary "dead" (non-live) properties that
+// clients attach to a resource via PROPPATCH, e.g. macOS Finder xattrs or
+// Office document metadata. Implementations must be safe for concurrent use.
+type DeadPropertyStore interface {
+	// GetProps returns the dead properties stored for path. If names is
+	// non-empty, only the requested properties are returned; a name with
+	// no stored value is simply omitted from the result.
+	GetProps(path string, names []xml.Name) (map[xml.Name]Property, error)
+
+	// PatchProps applies set and remove to the dead properties stored for
+	// path, and reports the resulting HTTP status for every property that
+	// was touched (201 for created, 200 for a plain update/removal).
+	PatchProps(path string, set, remove []Property) (map[xml.Name]int, error)
+
+	// PurgeProps discards every dead property stored for path, e.g. when the
+	// resource itself is deleted. A path with nothing stored is a no-op.
+	PurgeProps(path string) error
+}
+
+// MapDeadPropertyStore is an in-memory DeadPropertyStore backed by a map. It
+// is the default store used when a Server does not configure one, and is
+// handy for tests.
+type MapDeadPropertyStore struct {
+	mu    sync.Mutex
+	props map[string]map[xml.Name]Property
+}
+
+// NewMapDeadPropertyStore returns an empty, ready to use MapDeadPropertyStore.
+func NewMapDeadPropertyStore() *MapDeadPropertyStore {
+	return &MapDeadPropertyStore{props: map[string]map[xml.Name]Property{}}
+}
+
+func (m *MapDeadPropertyStore) GetProps(path string, names []xml.Name) (map[xml.Name]Property, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	all := m.props[path]
+	if len(names) == 0 {
+		ret := make(map[xml.Name]Property, len(all))
+		for n, p := range all {
+			ret[n] = p
+		}
+		return ret, nil
+	}
+
+	ret := make(map[xml.Name]Property, len(names))
+	for _, n := range names {
+		if p, ok := all[n]; ok {
+			ret[n] = p
+		}
+	}
+	return ret, nil
+}
+
+func (m *MapDeadPropertyStore) PatchProps(path string, set, remove []Property) (map[xml.Name]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[xml.Name]int, len(set)+len(remove))
+
+	all := m.props[path]
+	if all == nil && len(set) > 0 {
+		all = map[xml.Name]Property{}
+		m.props[path] = all
+	}
+
+	for _, p := range set {
+		if _, existed := all[p.XMLName]; existed {
+			result[p.XMLName] = StatusOK
+		} else {
+			result[p.XMLName] = StatusCreated
+		}
+		all[p.XMLName] = p
+	}
+
+	for _, p := range remove {
+		delete(all, p.XMLName)
+		result[p.XMLName] = StatusOK
+	}
+
+	return result, nil
+}
+
+func (m *MapDeadPropertyStore) PurgeProps(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.props, path)
+	return nil
+}
+
+// davName builds an xml.Name in the "DAV:" namespace, which is where all of
+// the RFC 4918 §15 live properties live.
+func davName(local string) xml.Name {
+	return xml.Name{Space: "DAV:", Local: local}
+}
+
+// liveProtectedNames are the RFC 4918 §15 live properties. All of them are
+// protected: PROPPATCH must reject any attempt to set or remove one with
+// 403 Forbidden rather than silently storing a dead-property shadow.
+// http://www.webdav.org/specs/rfc4918.html#rfc.section.9.2.1
+var liveProtectedNames = map[xml.Name]bool{
+	davName("displayname"):      true,
+	davName("getlastmodified"):  true,
+	davName("creationdate"):     true,
+	davName("resourcetype"):     true,
+	davName("getcontentlength"): true,
+	davName("getcontenttype"):   true,
+	davName("getetag"):          true,
+	davName("supportedlock"):    true,
+	davName("lockdiscovery"):    true,
+}
+
+// isLiveProperty reports whether name is one of the live properties above.
+func isLiveProperty(name xml.Name) bool {
+	return liveProtectedNames[name]
+}
+
+// liveProps returns the RFC 4918 §15 live properties for the resource at
+// path, using fi for the metadata that the filesystem already exposes.
+func (s *Server) liveProps(path string, fi os.FileInfo) map[xml.Name]Property {
+	props := map[xml.Name]Property{}
+
+	props[davName("displayname")] = Property{
+		XMLName:  davName("displayname"),
+		InnerXML: escapeXML(filepath.Base("/" + path)),
+	}
+
+	props[davName("getlastmodified")] = Property{
+		XMLName:  davName("getlastmodified"),
+		InnerXML: []byte(fi.ModTime().UTC().Format(http.TimeFormat)),
+	}
+
+	props[davName("creationdate")] = Property{
+		// Go's os.FileInfo has no concept of a creation time distinct from
+		// mtime, so fall back to ModTime; this matches most WebDAV servers
+		// running on POSIX filesystems.
+		XMLName:  davName("creationdate"),
+		InnerXML: []byte(fi.ModTime().UTC().Format(time.RFC3339)),
+	}
+
+	if fi.IsDir() {
+		props[davName("resourcetype")] = Property{
+			XMLName:  davName("resourcetype"),
+			InnerXML: []byte(`<D:collection xmlns:D="DAV:"/>`),
+		}
+	} else {
+		props[davName("resourcetype")] = Property{XMLName: davName("resourcetype")}
+
+		props[davName("getcontentlength")] = Property{
+			XMLName:  davName("getcontentlength"),
+			InnerXML: []byte(strconv.FormatInt(fi.Size(), 10)),
+		}
+
+		props[davName("getcontenttype")] = Property{
+			XMLName:  davName("getcontenttype"),
+			InnerXML: []byte(contentType(path)),
+		}
+
+		props[davName("getetag")] = Property{
+			XMLName:  davName("getetag"),
+			InnerXML: []byte(s.etag(path, fi)),
+		}
+	}
+
+	props[davName("supportedlock")] = Property{
+		XMLName: davName("supportedlock"),
+		InnerXML: []byte(`<D:lockentry xmlns:D="DAV:">` +
+			`<D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype>` +
+			`</D:lockentry>` +
+			`<D:lockentry xmlns:D="DAV:">` +
+			`<D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype>` +
+			`</D:lockentry>`),
+	}
+
+	props[davName("lockdiscovery")] = Property{
+		XMLName:  davName("lockdiscovery"),
+		InnerXML: []byte(s.lockDiscovery(path)),
+	}
+
+	return props
+}
+
+// etag returns an entity tag for the resource at path. Filesystems that can
+// supply a stronger tag (a content hash, a storage version id, ...) do so by
+// implementing ETager; otherwise a weak tag is derived from the
+// modification time and size.
+func (s *Server) etag(path string, fi os.FileInfo) string {
+	if et, ok := s.Fs.(ETager); ok {
+		if tag, err := et.ETag(path); err == nil && tag != "" {
+			return tag
+		}
+	}
+	return `W/"` + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + `-` + strconv.FormatInt(fi.Size(), 36) + `"`
+}
+
+// lockDiscovery renders the <D:lockdiscovery> value for path: one
+// <D:activelock> per lock that currently covers it.
+func (s *Server) lockDiscovery(path string) string {
+	active := s.locks().Lookup(time.Now(), path)
+	if len(active) == 0 {
+		return ""
+	}
+
+	buf := new(bytes.Buffer)
+	for _, l := range active {
+		buf.WriteString(activeLockXML(l))
+	}
+	return buf.String()
+}
+
+// escapeXML returns s with XML special characters escaped, suitable for use
+// as InnerXML in a Property that wraps plain text.
+func escapeXML(s string) []byte {
+	buf := new(bytes.Buffer)
+	xml.EscapeText(buf, []byte(s))
+	return buf.Bytes()
+}
+
+// contentType guesses a resource's content type from its file extension,
+// falling back to a generic octet-stream when unknown.
+func contentType(path string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// propstatGroup is a single <D:propstat> element: a set of properties (or,
+// for a PROPFIND ?propname request, names only) that share a status code.
+type propstatGroup struct {
+	status int
+	names  []xml.Name
+	props  map[xml.Name]Property
+}
+
+// msResponse is a single <D:response> element of a <D:multistatus> body.
+type msResponse struct {
+	href      string
+	status    int // used when there are no propstats, e.g. plain errors
+	propstats []propstatGroup
+}
+
+// writeMultiStatus renders responses as a complete RFC 4918 §13
+// <D:multistatus> document and writes it with a 207 status code.
+func writeMultiStatus(w http.ResponseWriter, responses []msResponse) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+
+	for _, r := range responses {
+		buf.WriteString(`<D:response><D:href>`)
+		xml.EscapeText(buf, []byte(r.href))
+		buf.WriteString(`</D:href>`)
+
+		if len(r.propstats) == 0 {
+			buf.WriteString(`<D:status>HTTP/1.1 ` + strconv.Itoa(r.status) + ` ` + StatusText(r.status) + `</D:status>`)
+		} else {
+			for _, ps := range r.propstats {
+				buf.WriteString(`<D:propstat><D:prop>`)
+				if ps.props != nil {
+					for _, name := range sortedNames(ps.props) {
+						p := ps.props[name]
+						writePropElement(buf, name, p.InnerXML)
+					}
+				} else {
+					for _, name := range ps.names {
+						writePropElement(buf, name, nil)
+					}
+				}
+				buf.WriteString(`</D:prop><D:status>HTTP/1.1 ` + strconv.Itoa(ps.status) + ` ` + StatusText(ps.status) + `</D:status></D:propstat>`)
+			}
+		}
+
+		buf.WriteString(`</D:response>`)
+	}
+
+	buf.WriteString(`</D:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	w.WriteHeader(StatusMulti)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func writePropElement(buf *bytes.Buffer, name xml.Name, innerXML []byte) {
+	tag := davTag(name)
+	start := tag
+	if name.Space != "DAV:" && name.Space != "" {
+		start += ` xmlns="` + string(escapeXML(name.Space)) + `"`
+	}
+	if len(innerXML) == 0 {
+		buf.WriteString(`<` + start + `/>`)
+		return
+	}
+	buf.WriteString(`<` + start + `>`)
+	buf.Write(innerXML)
+	buf.WriteString(`</` + tag + `>`)
+}
+
+// davTag renders name as "D:local" when it is in the DAV: namespace (or has
+// none), and as its bare local name otherwise; writePropElement declares that
+// name's namespace on the element, so dead properties round-trip intact.
+func davTag(name xml.Name) string {
+	if name.Space == "DAV:" || name.Space == "" {
+		return "D:" + name.Local
+	}
+	return name.Local
+}
+
+func sortedNames(props map[xml.Name]Property) []xml.Name {
+	names := make([]xml.Name, 0, len(props))
+	for n := range props {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+	return names
+}
+
+// propfindResponse builds the <D:response> for a single resource, resolving
+// each requested property against the live properties and the configured
+// DeadPropertyStore, and grouping hits/misses into propstats by status code.
+func (s *Server) propfindResponse(path string, fi os.FileInfo, propnames bool, properties []xml.Name) msResponse {
+	live := s.liveProps(path, fi)
+
+	dead := map[xml.Name]Property{}
+	if s.DeadProps != nil {
+		if d, err := s.DeadProps.GetProps(path, nil); err == nil {
+			dead = d
+		}
+	}
+
+	resp := msResponse{href: s.path2url(path).String()}
+
+	if len(properties) == 0 {
+		// allprop (or a bare PROPFIND with no body): every live and dead
+		// property, all reported as 200 OK.
+		found := map[xml.Name]Property{}
+		for n, p := range live {
+			found[n] = p
+		}
+		for n, p := range dead {
+			found[n] = p
+		}
+		if propnames {
+			resp.propstats = []propstatGroup{{status: StatusOK, names: sortedNames(found)}}
+		} else {
+			resp.propstats = []propstatGroup{{status: StatusOK, props: found}}
+		}
+		return resp
+	}
+
+	found := map[xml.Name]Property{}
+	var missing []xml.Name
+	for _, name := range properties {
+		if p, ok := live[name]; ok {
+			found[name] = p
+		} else if p, ok := dead[name]; ok {
+			found[name] = p
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(found) > 0 {
+		if propnames {
+			resp.propstats = append(resp.propstats, propstatGroup{status: StatusOK, names: sortedNames(found)})
+		} else {
+			resp.propstats = append(resp.propstats, propstatGroup{status: StatusOK, props: found})
+		}
+	}
+	if len(missing) > 0 {
+		resp.propstats = append(resp.propstats, propstatGroup{status: StatusNotFound, names: missing})
+	}
+
+	return resp
+}
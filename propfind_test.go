@@ -0,0 +1,87 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteMultiStatus(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	err := writeMultiStatus(rec, []msResponse{
+		{href: "/locked", status: StatusLocked},
+		{
+			href: "/a.txt",
+			propstats: []propstatGroup{
+				{status: StatusOK, names: []xml.Name{davName("displayname")}},
+				{status: StatusNotFound, names: []xml.Name{davName("getcontentlanguage")}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("writeMultiStatus: %v", err)
+	}
+
+	if rec.Code != StatusMulti {
+		t.Errorf("status = %d, want %d", rec.Code, StatusMulti)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.Contains(ct, "application/xml") {
+		t.Errorf("Content-Type = %q, want application/xml", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<D:multistatus xmlns:D="DAV:">`,
+		`<D:href>/locked</D:href>`,
+		`<D:href>/a.txt</D:href>`,
+		`<D:prop><D:displayname/></D:prop>`,
+		`<D:prop><D:getcontentlanguage/></D:prop>`,
+		`</D:multistatus>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q; got %s", want, body)
+		}
+	}
+}
+
+func TestMapDeadPropertyStorePatchProps(t *testing.T) {
+	store := NewMapDeadPropertyStore()
+	name := xml.Name{Space: "urn:test", Local: "color"}
+
+	results, err := store.PatchProps("/a.txt", []Property{{XMLName: name, InnerXML: []byte("red")}}, nil)
+	if err != nil {
+		t.Fatalf("PatchProps (create): %v", err)
+	}
+	if results[name] != StatusCreated {
+		t.Errorf("status for new property = %d, want %d", results[name], StatusCreated)
+	}
+
+	results, err = store.PatchProps("/a.txt", []Property{{XMLName: name, InnerXML: []byte("blue")}}, nil)
+	if err != nil {
+		t.Fatalf("PatchProps (update): %v", err)
+	}
+	if results[name] != StatusOK {
+		t.Errorf("status for existing property = %d, want %d", results[name], StatusOK)
+	}
+
+	props, err := store.GetProps("/a.txt", nil)
+	if err != nil {
+		t.Fatalf("GetProps: %v", err)
+	}
+	if string(props[name].InnerXML) != "blue" {
+		t.Errorf("stored value = %q, want %q", props[name].InnerXML, "blue")
+	}
+
+	if _, err := store.PatchProps("/a.txt", nil, []Property{{XMLName: name}}); err != nil {
+		t.Fatalf("PatchProps (remove): %v", err)
+	}
+	if err := store.PurgeProps("/a.txt"); err != nil {
+		t.Fatalf("PurgeProps: %v", err)
+	}
+	props, _ = store.GetProps("/a.txt", nil)
+	if len(props) != 0 {
+		t.Errorf("GetProps after purge = %#v, want empty", props)
+	}
+}
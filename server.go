@@ -2,10 +2,11 @@ package webdav
 
 import (
 	"bytes"
-	"log"
+	"encoding/xml"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 func Handler(root FileSystem) http.Handler {
@@ -21,45 +22,123 @@ type Server struct {
 
 	// access to a collection of named files
 	Fs FileSystem
+
+	// DeadProps stores dead (non-live) properties set via PROPPATCH, such as
+	// macOS Finder xattrs or Office metadata. Defaults to a fresh
+	// MapDeadPropertyStore when nil.
+	DeadProps DeadPropertyStore
+
+	// Locks backs LOCK/UNLOCK and the 423 enforcement on every other write
+	// method. Defaults to a fresh MemLS when nil.
+	Locks LockSystem
+
+	// Authenticator validates every request but OPTIONS. A nil
+	// Authenticator serves all requests unauthenticated.
+	Authenticator Authenticator
+
+	// FileSystemProvider derives the FileSystem to serve an authenticated
+	// request from, letting a single Server host multiple users with
+	// isolated roots. It is only consulted when Authenticator is set; if
+	// nil, every principal shares Fs.
+	FileSystemProvider func(Principal) FileSystem
+
+	// Logger receives every diagnostic and access-log message. Defaults to
+	// an slog-backed Logger wrapping slog.Default() when nil.
+	Logger Logger
+
+	// reqLogger is Logger bound with the current request's attributes; set
+	// by ServeHTTP on a per-request copy of Server, nil otherwise.
+	reqLogger Logger
+}
+
+func (s *Server) deadProps() DeadPropertyStore {
+	if s.DeadProps == nil {
+		s.DeadProps = NewMapDeadPropertyStore()
+	}
+	return s.DeadProps
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Println("DAV:", r.RemoteAddr, r.Method, r.URL)
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+
+	// Resolve the lazy defaults on the real *s before taking the
+	// per-request copy below, so the fresh MemLS/MapDeadPropertyStore they
+	// create is shared by every request rather than thrown away with the
+	// copy that created it.
+	s.deadProps()
+	s.locks()
+
+	scoped := *s
+	scoped.reqLogger = s.logger().With(
+		"method", r.Method,
+		"url", r.URL.String(),
+		"remote", r.RemoteAddr,
+		"depth", r.Header.Get("Depth"),
+		"destination", r.Header.Get("Destination"),
+		"lock-token", r.Header.Get("Lock-Token"),
+	)
+	active := &scoped
+
+	defer func() {
+		active.logger().Info("request completed", "status", rec.status, "duration", time.Since(start))
+	}()
+
+	// OPTIONS must work unauthenticated so clients can probe DAV compliance
+	// before they have credentials to offer.
+	if r.Method == "OPTIONS" {
+		active.doOptions(rec, r)
+		return
+	}
 
-	switch r.Method {
-	case "OPTIONS":
-		s.doOptions(w, r)
+	if s.Authenticator != nil {
+		principal, err := s.Authenticator.Authenticate(r)
+		if err != nil {
+			for _, challenge := range s.Authenticator.Challenges() {
+				rec.Header().Add("WWW-Authenticate", challenge)
+			}
+			rec.WriteHeader(StatusUnauthorized)
+			return
+		}
 
+		active.reqLogger = active.reqLogger.With("principal", principal.Name)
+
+		if s.FileSystemProvider != nil {
+			active.Fs = s.FileSystemProvider(principal)
+		}
+	}
+
+	switch r.Method {
 	case "GET":
-		s.doGet(w, r)
+		active.doGet(rec, r)
 	case "HEAD":
-		s.doHead(w, r)
+		active.doHead(rec, r)
 	case "POST":
-		s.doPost(w, r)
+		active.doPost(rec, r)
 	case "DELETE":
-		s.doDelete(w, r)
+		active.doDelete(rec, r)
 	case "PUT":
-		s.doPut(w, r)
+		active.doPut(rec, r)
 
 	case "PROPFIND":
-		s.doPropfind(w, r)
+		active.doPropfind(rec, r)
 	case "PROPPATCH":
-		s.doProppatch(w, r)
+		active.doProppatch(rec, r)
 	case "MKCOL":
-		s.doMkcol(w, r)
+		active.doMkcol(rec, r)
 	case "COPY":
-		s.doCopy(w, r)
+		active.doCopy(rec, r)
 	case "MOVE":
-		s.doMove(w, r)
+		active.doMove(rec, r)
 
 	case "LOCK":
-		s.doLock(w, r)
+		active.doLock(rec, r)
 	case "UNLOCK":
-		s.doUnlock(w, r)
+		active.doUnlock(rec, r)
 
 	default:
-		log.Println("DAV:", "unknown method", r.Method)
-		w.WriteHeader(StatusBadRequest)
+		active.logger().Warn("unknown method", "method", r.Method)
+		rec.WriteHeader(StatusBadRequest)
 	}
 }
 
@@ -145,25 +224,25 @@ func (s *Server) directoryContents(path string) []string {
 	return ret
 }
 
-// is path in request locked?
-func (s *Server) isLockedRequest(r *http.Request) bool {
-	return s.isLocked(
-		s.url2path(r.URL),
-		r.Header.Get("If")+r.Header.Get("Lock-Token"))
-}
-
-// is path locked?
-func (s *Server) isLocked(path, ifHeader string) bool {
-	// TODO
-	return false
-}
+// childPaths returns the full paths of the immediate members of the
+// collection at path, suitable for Depth: 1 requests.
+func (s *Server) childPaths(path string) []string {
+	f, err := s.Fs.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
 
-func (s *Server) lockResource(path string) {
-	// TODO
-}
+	fi, err := f.Readdir(0)
+	if err != nil {
+		return nil
+	}
 
-func (s *Server) unlockResource(path string) {
-	// TODO
+	ret := make([]string, len(fi))
+	for k, i := range fi {
+		ret[k] = strings.Trim(path, "/") + "/" + i.Name()
+	}
+	return ret
 }
 
 // The PROPFIND method retrieves properties defined on the resource identified by the Request-URI
@@ -175,12 +254,6 @@ func (s *Server) doPropfind(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	/*
-		TODO:
-			return only directory and ics-file
-			of current user
-	*/
-
 	depth := r.Header.Get("Depth")
 	switch depth {
 	case "0", "1":
@@ -191,19 +264,18 @@ func (s *Server) doPropfind(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(StatusForbidden)
 		return
 	default:
-		log.Println("DAV:", "invalid depth header", depth)
+		s.logger().Warn("invalid depth header", "depth", depth)
 		w.WriteHeader(StatusBadRequest)
 		return
 	}
 
 	var propnames bool
-	var properties []string
-	var includes []string
+	var properties []xml.Name
 
 	if r.ContentLength > 0 {
 		propfind, err := NodeFromXml(r.Body)
 		if err != nil {
-			log.Println("DAV:", "invalid propfind xml", err)
+			s.logger().Warn("invalid propfind xml", "error", err)
 			w.WriteHeader(StatusBadRequest)
 			return
 		}
@@ -212,7 +284,7 @@ func (s *Server) doPropfind(w http.ResponseWriter, r *http.Request) {
 		// http://www.webdav.org/specs/rfc4918.html#dav.properties
 		if propfind.HasChildren("prop") {
 			for _, p := range propfind.GetChildrens("prop") {
-				properties = append(properties, p.Name.Local)
+				properties = append(properties, p.Name)
 			}
 		}
 
@@ -221,14 +293,9 @@ func (s *Server) doPropfind(w http.ResponseWriter, r *http.Request) {
 			propnames = true
 		}
 
-		// find all properties
-		if propfind.HasChildren("allprop") {
-			if propfind.HasChildren("include") {
-				for _, i := range propfind.GetChildrens("include") {
-					includes = append(includes, i.Name.Local)
-				}
-			}
-		}
+		// find all properties; RFC 4918 allows <include> to request
+		// additional properties alongside allprop, but since every live
+		// property is already returned for allprop, there is nothing to add.
 	}
 
 	path := s.url2path(r.URL)
@@ -238,24 +305,28 @@ func (s *Server) doPropfind(w http.ResponseWriter, r *http.Request) {
 	}
 
 	paths := []string{path}
-	if depth == "1" {
-		// fetch all files if directory
-		// respect []includes
+	if depth == "1" && s.pathIsDirectory(path) {
+		paths = append(paths, s.childPaths(path)...)
 	}
 
-	log.Println("propnames", propnames)
-
-	w.WriteHeader(StatusMulti)
-	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	var responses []msResponse
 	for _, p := range paths {
-		// test locks/ authorization
-		// if properties, show only given properties, else all
-		// if propnames, return names of properties, else names and values
-		log.Println(p)
+		p = strings.TrimSuffix(p, "/")
+
+		f, err := s.Fs.Open(p)
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		f.Close()
+		if err != nil {
+			continue
+		}
+
+		responses = append(responses, s.propfindResponse(p, fi, propnames, properties))
 	}
 
-	// TODO: propfind
-	w.WriteHeader(StatusNotImplemented)
+	writeMultiStatus(w, responses)
 }
 
 // http://www.webdav.org/specs/rfc4918.html#METHOD_PROPPATCH
@@ -265,13 +336,135 @@ func (s *Server) doProppatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
+	path := s.url2path(r.URL)
+	if _, locked := s.isLocked(path, r); locked {
+		writeLockedError(w, s, []string{path})
 		return
 	}
 
-	// TODO: proppatch
-	w.WriteHeader(StatusNotImplemented)
+	if !s.pathExists(path) {
+		w.WriteHeader(StatusNotFound)
+		return
+	}
+
+	root, err := NodeFromXml(r.Body)
+	if err != nil {
+		s.logger().Warn("invalid proppatch xml", "error", err)
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+
+	var set, remove []Property
+	for _, n := range root.GetChildrens("set") {
+		for _, p := range n.GetChildrens("prop") {
+			set = append(set, Property{XMLName: p.Name, InnerXML: p.InnerXml()})
+		}
+	}
+	for _, n := range root.GetChildrens("remove") {
+		for _, p := range n.GetChildrens("prop") {
+			remove = append(remove, Property{XMLName: p.Name})
+		}
+	}
+
+	// Live properties are computed, not stored, so they can't be set or
+	// removed through the dead-property store; carve them out up front and
+	// fail them with 403, same as any other per-property failure.
+	// http://www.webdav.org/specs/rfc4918.html#rfc.section.9.2.1
+	forbidden := map[xml.Name]int{}
+	set = filterLiveProps(set, forbidden)
+	remove = filterLiveProps(remove, forbidden)
+
+	// Snapshot the prior value of every property this request touches, so a
+	// failure can be rolled back to that exact state rather than merely
+	// deleted.
+	touched := make([]xml.Name, 0, len(set)+len(remove)+len(forbidden))
+	for _, p := range set {
+		touched = append(touched, p.XMLName)
+	}
+	for _, p := range remove {
+		touched = append(touched, p.XMLName)
+	}
+	for name := range forbidden {
+		touched = append(touched, name)
+	}
+	before, err := s.deadProps().GetProps(path, touched)
+	if err != nil {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	results, err := s.deadProps().PatchProps(path, set, remove)
+	if err != nil {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+	for name, status := range forbidden {
+		results[name] = status
+	}
+
+	// RFC 4918 §9.2: PROPPATCH instructions MUST be processed atomically. If
+	// any property could not be set or removed, every other property in the
+	// request is rolled back to its prior value and reported as 424 Failed
+	// Dependency; the property that actually failed keeps its own status.
+	failed := false
+	for _, status := range results {
+		if status >= 400 {
+			failed = true
+			break
+		}
+	}
+
+	var propstats []propstatGroup
+	if failed {
+		var restoreSet, restoreRemove []Property
+		for _, name := range touched {
+			if results[name] >= 400 {
+				continue
+			}
+			if p, existed := before[name]; existed {
+				restoreSet = append(restoreSet, p)
+			} else {
+				restoreRemove = append(restoreRemove, Property{XMLName: name})
+			}
+		}
+		s.deadProps().PatchProps(path, restoreSet, restoreRemove)
+
+		byStatus := map[int][]xml.Name{}
+		for _, name := range touched {
+			status := results[name]
+			if status < 400 {
+				status = StatusFailedDependency
+			}
+			byStatus[status] = append(byStatus[status], name)
+		}
+		for status, names := range byStatus {
+			propstats = append(propstats, propstatGroup{status: status, names: names})
+		}
+	} else {
+		byStatus := map[int][]xml.Name{}
+		for name, status := range results {
+			byStatus[status] = append(byStatus[status], name)
+		}
+		for status, names := range byStatus {
+			propstats = append(propstats, propstatGroup{status: status, names: names})
+		}
+	}
+
+	writeMultiStatus(w, []msResponse{{href: s.path2url(path).String(), propstats: propstats}})
+}
+
+// filterLiveProps splits props into the subset that isn't a live property,
+// recording a 403 in forbidden for each one that is.
+func filterLiveProps(props []Property, forbidden map[xml.Name]int) []Property {
+	kept := props[:0]
+	for _, p := range props {
+		if isLiveProperty(p.XMLName) {
+			forbidden[p.XMLName] = StatusForbidden
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
 }
 
 // http://www.webdav.org/specs/rfc4918.html#METHOD_MKCOL
@@ -281,12 +474,12 @@ func (s *Server) doMkcol(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
+	path := s.url2path(r.URL)
+	if _, locked := s.isLocked(path, r); locked {
+		writeLockedError(w, s, []string{path})
 		return
 	}
 
-	path := s.url2path(r.URL)
 	if s.pathExists(path) {
 		w.Header().Set("Allow", s.methodsAllowed(s.url2path(r.URL)))
 		w.WriteHeader(StatusMethodNotAllowed)
@@ -311,17 +504,18 @@ func (s *Server) doMkcol(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(StatusCreated)
-	s.unlockResource(path)
 }
 
 // http://www.webdav.org/specs/rfc4918.html#rfc.section.9.4
 func (s *Server) doGet(w http.ResponseWriter, r *http.Request) {
-	s.serveResource(w, r, true)
+	s.serveResource(w, r)
 }
 
 // http://www.webdav.org/specs/rfc4918.html#rfc.section.9.4
 func (s *Server) doHead(w http.ResponseWriter, r *http.Request) {
-	s.serveResource(w, r, false)
+	// http.ServeContent already skips the body for a HEAD request, so GET
+	// and HEAD share the same implementation.
+	s.serveResource(w, r)
 }
 
 // http://www.webdav.org/specs/rfc4918.html#METHOD_POST
@@ -329,12 +523,6 @@ func (s *Server) doPost(w http.ResponseWriter, r *http.Request) {
 	s.doGet(w, r)
 }
 
-func (s *Server) serveResource(w http.ResponseWriter, r *http.Request, serveContent bool) {
-	// TODO: get/head
-	// path := url2path(r.URL)
-	w.WriteHeader(StatusNotImplemented)
-}
-
 // http://www.webdav.org/specs/rfc4918.html#METHOD_DELETE
 func (s *Server) doDelete(w http.ResponseWriter, r *http.Request) {
 	if s.ReadOnly {
@@ -342,17 +530,18 @@ func (s *Server) doDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
+	path := s.url2path(r.URL)
+	if _, locked := s.isLocked(path, r); locked {
+		writeLockedError(w, s, []string{path})
 		return
 	}
 
-	s.deleteResource(s.url2path(r.URL), w, r, true)
+	s.deleteResource(path, w, r, true)
 }
 
 func (s *Server) deleteResource(path string, w http.ResponseWriter, r *http.Request, setStatus bool) bool {
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
+	if _, locked := s.isLocked(path, r); locked {
+		writeLockedError(w, s, []string{path})
 		return false
 	}
 
@@ -366,6 +555,7 @@ func (s *Server) deleteResource(path string, w http.ResponseWriter, r *http.Requ
 			w.WriteHeader(StatusInternalServerError)
 			return false
 		}
+		s.deadProps().PurgeProps(path)
 	} else {
 		// http://www.webdav.org/specs/rfc4918.html#delete-collections
 		errors := map[string]int{}
@@ -373,30 +563,16 @@ func (s *Server) deleteResource(path string, w http.ResponseWriter, r *http.Requ
 
 		if err := s.Fs.Remove(path); err != nil {
 			errors[path] = StatusInternalServerError
+		} else {
+			s.deadProps().PurgeProps(path)
 		}
 
 		if len(errors) != 0 {
-			// send multistatus
-			abs := r.RequestURI
-
-			buf := new(bytes.Buffer)
-			buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
-			buf.WriteString(`<multistatus xmlns='DAV:'>`)
-
-			for p, e := range errors {
-				buf.WriteString(`<response>`)
-				buf.WriteString(`<href>` + abs + p + `</href>`)
-				buf.WriteString(`<status>HTTP/1.1 ` + string(e) + ` ` + StatusText(e) + `</status>`)
-				buf.WriteString(`</response>`)
+			responses := make([]msResponse, 0, len(errors))
+			for p, status := range errors {
+				responses = append(responses, msResponse{href: s.path2url(p).String(), status: status})
 			}
-
-			buf.WriteString(`</multistatus>`)
-
-			w.WriteHeader(StatusMulti)
-			w.Header().Set("Content-Length", string(buf.Len()))
-			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-			buf.WriteTo(w)
-
+			writeMultiStatus(w, responses)
 			return false
 		}
 	}
@@ -408,11 +584,8 @@ func (s *Server) deleteResource(path string, w http.ResponseWriter, r *http.Requ
 }
 
 func (s *Server) deleteCollection(path string, w http.ResponseWriter, r *http.Request, errors map[string]int) {
-	ifHeader := r.Header.Get("If")
-	lockToken := r.Header.Get("Lock-Token")
-
-	for _, p := range s.directoryContents(path) {
-		if s.isLocked(p, ifHeader+lockToken) {
+	for _, p := range s.childPaths(path) {
+		if _, locked := s.isLocked(p, r); locked {
 			errors[p] = StatusLocked
 		} else {
 			if s.pathIsDirectory(p) {
@@ -421,30 +594,14 @@ func (s *Server) deleteCollection(path string, w http.ResponseWriter, r *http.Re
 
 			if err := s.Fs.Remove(p); err != nil {
 				errors[p] = StatusInternalServerError
+			} else {
+				s.deadProps().PurgeProps(p)
 			}
 		}
 	}
 
 }
 
-// http://www.webdav.org/specs/rfc4918.html#METHOD_PUT
-func (s *Server) doPut(w http.ResponseWriter, r *http.Request) {
-	if s.ReadOnly {
-		w.WriteHeader(StatusForbidden)
-		return
-	}
-
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
-		return
-	}
-
-	// TODO: put
-	// path := url2path(r.URL)
-	// exists := pathExists(path)
-	w.WriteHeader(StatusNotImplemented)
-}
-
 // http://www.webdav.org/specs/rfc4918.html#METHOD_COPY
 func (s *Server) doCopy(w http.ResponseWriter, r *http.Request) {
 	if s.ReadOnly {
@@ -462,50 +619,72 @@ func (s *Server) doMove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
+	// Unlike COPY, MOVE has no partial-depth mode: it always acts as if
+	// Depth: infinity were given, so any other value is rejected rather than
+	// silently moving only part of the tree.
+	// http://www.webdav.org/specs/rfc4918.html#rfc.section.9.9.2
+	if depth := r.Header.Get("Depth"); depth != "" && depth != "infinity" {
+		w.WriteHeader(StatusBadRequest)
 		return
 	}
 
-	if s.copyResource(w, r) {
-		s.deleteResource(s.url2path(r.URL), w, r, false)
+	path := s.url2path(r.URL)
+	if _, locked := s.isLocked(path, r); locked {
+		writeLockedError(w, s, []string{path})
+		return
 	}
-}
-
-func (s *Server) copyResource(w http.ResponseWriter, r *http.Request) bool {
-	// TODO: copy resource
-	w.WriteHeader(StatusNotImplemented)
-	return false
-}
 
-func (s *Server) doLock(w http.ResponseWriter, r *http.Request) {
-	if s.ReadOnly {
-		w.WriteHeader(StatusForbidden)
+	// copyResource writes its outcome straight to the ResponseWriter, but a
+	// MOVE isn't done once the copy succeeds: the source still has to be
+	// deleted, and that can itself fail partway through a collection. Buffer
+	// the copy's response so it can be discarded in favor of deleteResource's
+	// own failure/partial-failure response instead of being followed by a
+	// second, malformed write to w.
+	buf := newDeferredResponseWriter()
+	ok, samePath := s.copyResource(buf, r)
+	if !ok || samePath {
+		buf.flushTo(w)
 		return
 	}
 
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
+	if !s.deleteResource(path, w, r, false) {
+		// deleteResource already wrote its own failure/partial-failure
+		// response; discard the copy's buffered success status.
 		return
 	}
 
-	// TODO: lock
-	w.WriteHeader(StatusNotImplemented)
+	buf.flushTo(w)
 }
 
-func (s *Server) doUnlock(w http.ResponseWriter, r *http.Request) {
-	if s.ReadOnly {
-		w.WriteHeader(StatusForbidden)
-		return
-	}
+// deferredResponseWriter buffers a response so the caller can decide whether
+// to flush it to the real ResponseWriter or discard it in favor of a
+// response written elsewhere.
+type deferredResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
 
-	if s.isLockedRequest(r) {
-		w.WriteHeader(StatusLocked)
-		return
-	}
+func newDeferredResponseWriter() *deferredResponseWriter {
+	return &deferredResponseWriter{header: http.Header{}}
+}
+
+func (d *deferredResponseWriter) Header() http.Header { return d.header }
+
+func (d *deferredResponseWriter) Write(p []byte) (int, error) { return d.body.Write(p) }
 
-	// TODO: unlock
-	w.WriteHeader(StatusNotImplemented)
+func (d *deferredResponseWriter) WriteHeader(status int) { d.status = status }
+
+// flushTo copies the buffered response to w.
+func (d *deferredResponseWriter) flushTo(w http.ResponseWriter) {
+	header := w.Header()
+	for k, v := range d.header {
+		header[k] = v
+	}
+	if d.status != 0 {
+		w.WriteHeader(d.status)
+	}
+	w.Write(d.body.Bytes())
 }
 
 func (s *Server) doOptions(w http.ResponseWriter, r *http.Request) {
@@ -514,4 +693,4 @@ func (s *Server) doOptions(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Allow", s.methodsAllowed(s.url2path(r.URL)))
 	w.Header().Set("MS-Author-Via", "DAV")
-}
\ No newline at end of file
+}